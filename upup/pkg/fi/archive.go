@@ -0,0 +1,268 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fi
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/klog/v2"
+)
+
+// ArchiveFormat identifies the container format of an archive asset.
+type ArchiveFormat string
+
+const (
+	ArchiveFormatUnknown ArchiveFormat = ""
+	ArchiveFormatTar     ArchiveFormat = "tar"
+	ArchiveFormatTarGz   ArchiveFormat = "tar.gz"
+	ArchiveFormatTarBz2  ArchiveFormat = "tar.bz2"
+	ArchiveFormatZip     ArchiveFormat = "zip"
+)
+
+// maxExtractedFileSize bounds how much data we will read from any single
+// archive member, so that a maliciously crafted archive (a "zip bomb")
+// cannot exhaust disk space on the operator host.
+const maxExtractedFileSize = 2 << 30 // 2GiB
+
+// archiveMagicBytes are the byte sequences we use to recognize archive
+// formats when the URL does not carry a recognizable suffix.
+var archiveMagicBytes = []struct {
+	format ArchiveFormat
+	magic  []byte
+}{
+	{ArchiveFormatZip, []byte{0x50, 0x4b, 0x03, 0x04}},
+	{ArchiveFormatTarGz, []byte{0x1f, 0x8b}},
+	{ArchiveFormatTarBz2, []byte{0x42, 0x5a, 0x68}},
+}
+
+// archiveFormatFromSuffix returns the archive format implied by url's
+// suffix, or ArchiveFormatUnknown if url doesn't carry a suffix we
+// recognize. Unlike magic-byte sniffing, a suffix match is what the asset
+// was actually published as, so callers can treat it as authoritative.
+func archiveFormatFromSuffix(url string) ArchiveFormat {
+	lowerURL := strings.ToLower(url)
+	switch {
+	case strings.HasSuffix(lowerURL, ".tar.gz"), strings.HasSuffix(lowerURL, ".tgz"):
+		return ArchiveFormatTarGz
+	case strings.HasSuffix(lowerURL, ".tar.bz2"), strings.HasSuffix(lowerURL, ".tbz2"):
+		return ArchiveFormatTarBz2
+	case strings.HasSuffix(lowerURL, ".zip"):
+		return ArchiveFormatZip
+	case strings.HasSuffix(lowerURL, ".tar"):
+		return ArchiveFormatTar
+	}
+	return ArchiveFormatUnknown
+}
+
+// detectArchiveFormat determines the archive format of a file, first by
+// inspecting the URL suffix it was downloaded from, then by sniffing the
+// file's magic bytes.
+func detectArchiveFormat(url string, localFile string) (ArchiveFormat, error) {
+	if format := archiveFormatFromSuffix(url); format != ArchiveFormatUnknown {
+		return format, nil
+	}
+
+	f, err := os.Open(localFile)
+	if err != nil {
+		return ArchiveFormatUnknown, fmt.Errorf("error opening %q to detect archive format: %v", localFile, err)
+	}
+	defer f.Close()
+
+	header := make([]byte, 4)
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return ArchiveFormatUnknown, fmt.Errorf("error reading %q to detect archive format: %v", localFile, err)
+	}
+	header = header[:n]
+
+	for _, candidate := range archiveMagicBytes {
+		if len(header) >= len(candidate.magic) && bytesEqual(header[:len(candidate.magic)], candidate.magic) {
+			return candidate.format, nil
+		}
+	}
+
+	// A bare tar file has no reliable magic number at offset 0 (the magic
+	// "ustar" lives at offset 257), so fall back to a tar reader probe.
+	if _, err := f.Seek(0, io.SeekStart); err == nil {
+		tr := tar.NewReader(f)
+		if _, err := tr.Next(); err == nil {
+			return ArchiveFormatTar, nil
+		}
+	}
+
+	return ArchiveFormatUnknown, fmt.Errorf("unable to determine archive format of %q", localFile)
+}
+
+func bytesEqual(a, b []byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// extractArchive extracts archiveFile (in the given format) into destDir,
+// which must already exist. It rejects entries that would escape destDir.
+func extractArchive(format ArchiveFormat, archiveFile string, destDir string) error {
+	switch format {
+	case ArchiveFormatZip:
+		return extractZip(archiveFile, destDir)
+	case ArchiveFormatTar, ArchiveFormatTarGz, ArchiveFormatTarBz2:
+		return extractTar(format, archiveFile, destDir)
+	default:
+		return fmt.Errorf("unsupported archive format %q for %q", format, archiveFile)
+	}
+}
+
+func extractTar(format ArchiveFormat, archiveFile string, destDir string) error {
+	f, err := os.Open(archiveFile)
+	if err != nil {
+		return fmt.Errorf("error opening archive %q: %v", archiveFile, err)
+	}
+	defer f.Close()
+
+	var r io.Reader = bufio.NewReader(f)
+	switch format {
+	case ArchiveFormatTarGz:
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return fmt.Errorf("error reading gzip header in %q: %v", archiveFile, err)
+		}
+		defer gz.Close()
+		r = gz
+	case ArchiveFormatTarBz2:
+		r = bzip2.NewReader(r)
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading tar archive %q: %v", archiveFile, err)
+		}
+
+		destPath, err := safeJoin(destDir, header.Name)
+		if err != nil {
+			return fmt.Errorf("error extracting %q: %v", archiveFile, err)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(destPath, os.FileMode(header.Mode)|0700); err != nil {
+				return fmt.Errorf("error creating directory %q: %v", destPath, err)
+			}
+		case tar.TypeReg:
+			if err := writeExtractedFile(destPath, tr, os.FileMode(header.Mode)); err != nil {
+				return err
+			}
+		default:
+			// Skip symlinks, devices, etc; kops archives only ever ship regular files.
+			klog.V(2).Infof("skipping tar entry %q with type %v", header.Name, header.Typeflag)
+		}
+	}
+}
+
+func extractZip(archiveFile string, destDir string) error {
+	zr, err := zip.OpenReader(archiveFile)
+	if err != nil {
+		return fmt.Errorf("error opening zip archive %q: %v", archiveFile, err)
+	}
+	defer zr.Close()
+
+	for _, f := range zr.File {
+		destPath, err := safeJoin(destDir, f.Name)
+		if err != nil {
+			return fmt.Errorf("error extracting %q: %v", archiveFile, err)
+		}
+
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(destPath, f.Mode()|0700); err != nil {
+				return fmt.Errorf("error creating directory %q: %v", destPath, err)
+			}
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("error reading zip entry %q: %v", f.Name, err)
+		}
+		err = writeExtractedFile(destPath, rc, f.Mode())
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// safeJoin joins destDir and name, rejecting any entry whose cleaned path
+// would escape destDir (a "zip slip" / path traversal attack).
+func safeJoin(destDir string, name string) (string, error) {
+	cleaned := filepath.Clean(name)
+	if filepath.IsAbs(cleaned) || strings.HasPrefix(cleaned, ".."+string(filepath.Separator)) || cleaned == ".." {
+		return "", fmt.Errorf("archive entry %q escapes destination directory", name)
+	}
+	return filepath.Join(destDir, cleaned), nil
+}
+
+// writeExtractedFile writes r to destPath, refusing to write more than
+// maxExtractedFileSize bytes. It reads one byte past the limit so that an
+// oversized member is detected and reported as an error, rather than
+// silently truncated and reported as a successfully extracted asset.
+func writeExtractedFile(destPath string, r io.Reader, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		return fmt.Errorf("error creating directory %q: %v", filepath.Dir(destPath), err)
+	}
+
+	if mode == 0 {
+		mode = 0644
+	}
+
+	out, err := os.OpenFile(destPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return fmt.Errorf("error creating file %q: %v", destPath, err)
+	}
+	defer out.Close()
+
+	limited := io.LimitReader(r, maxExtractedFileSize+1)
+	n, err := io.Copy(out, limited)
+	if err != nil {
+		return fmt.Errorf("error writing file %q: %v", destPath, err)
+	}
+	if n > maxExtractedFileSize {
+		return fmt.Errorf("archive entry %q exceeds maximum allowed size of %d bytes", destPath, maxExtractedFileSize)
+	}
+
+	return nil
+}
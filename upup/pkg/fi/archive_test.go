@@ -0,0 +1,78 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fi
+
+import "testing"
+
+func TestSafeJoin(t *testing.T) {
+	grid := []struct {
+		name    string
+		entry   string
+		wantErr bool
+	}{
+		{name: "simple file", entry: "bin/kubelet"},
+		{name: "nested file", entry: "a/b/c.txt"},
+		{name: "dot prefix", entry: "./bin/kubelet"},
+		{name: "parent traversal", entry: "../../etc/passwd", wantErr: true},
+		{name: "embedded traversal", entry: "bin/../../etc/passwd", wantErr: true},
+		{name: "bare parent", entry: "..", wantErr: true},
+		{name: "absolute path", entry: "/etc/passwd", wantErr: true},
+	}
+
+	for _, g := range grid {
+		t.Run(g.name, func(t *testing.T) {
+			got, err := safeJoin("/dest", g.entry)
+			if g.wantErr {
+				if err == nil {
+					t.Errorf("safeJoin(%q) = %q, want error", g.entry, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("safeJoin(%q) returned unexpected error: %v", g.entry, err)
+			}
+		})
+	}
+}
+
+func TestDetectArchiveFormatFromSuffix(t *testing.T) {
+	grid := []struct {
+		url  string
+		want ArchiveFormat
+	}{
+		{url: "https://example.com/kubelet.tar.gz", want: ArchiveFormatTarGz},
+		{url: "https://example.com/kubelet.tgz", want: ArchiveFormatTarGz},
+		{url: "https://example.com/kubelet.tar.bz2", want: ArchiveFormatTarBz2},
+		{url: "https://example.com/kubelet.tbz2", want: ArchiveFormatTarBz2},
+		{url: "https://example.com/kubelet.zip", want: ArchiveFormatZip},
+		{url: "https://example.com/kubelet.tar", want: ArchiveFormatTar},
+	}
+
+	for _, g := range grid {
+		t.Run(g.url, func(t *testing.T) {
+			// A nonexistent localFile is fine here: the suffix match short-circuits
+			// before the function ever tries to open it.
+			got, err := detectArchiveFormat(g.url, "/does/not/exist")
+			if err != nil {
+				t.Fatalf("detectArchiveFormat(%q) returned error: %v", g.url, err)
+			}
+			if got != g.want {
+				t.Errorf("detectArchiveFormat(%q) = %q, want %q", g.url, got, g.want)
+			}
+		})
+	}
+}
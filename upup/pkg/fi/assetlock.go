@@ -0,0 +1,238 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fi
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+
+	"k8s.io/klog/v2"
+	"k8s.io/kops/upup/pkg/fi/utils"
+	"k8s.io/kops/util/pkg/hashing"
+	"sigs.k8s.io/yaml"
+)
+
+// lockFileVersion is the schema version written to new lockfiles, so that
+// future format changes can be migrated on read.
+const lockFileVersion = "v1alpha1"
+
+// LockFile is the on-disk (YAML or JSON) representation of a kops asset
+// lockfile: a reproducible, auditable manifest of every binary a cluster
+// pulls, generated once with AddResource and Save and shipped alongside
+// the cluster spec for air-gapped operation.
+type LockFile struct {
+	Version string       `json:"version"`
+	Assets  []*LockEntry `json:"assets"`
+}
+
+// LockEntry describes a single locked asset: where it can be fetched from,
+// how to verify it, and the tags used to select it (e.g. "cni",
+// "containerd", "arch:amd64").
+type LockEntry struct {
+	URLs      []string `json:"urls"`
+	Algorithm string   `json:"algorithm"`
+	Digest    string   `json:"digest"`
+	Filename  string   `json:"filename,omitempty"`
+	Mode      string   `json:"mode,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+}
+
+// hash returns the parsed hashing.Hash for this entry.
+func (e *LockEntry) hash() (*hashing.Hash, error) {
+	algorithm, err := hashing.HashAlgorithmFromString(e.Algorithm)
+	if err != nil {
+		return nil, fmt.Errorf("unknown hash algorithm %q for asset %v: %v", e.Algorithm, e.URLs, err)
+	}
+	return algorithm.FromString(e.Digest)
+}
+
+// hasTags returns true if the entry carries every tag in include and none
+// of the tags in exclude.
+func (e *LockEntry) hasTags(include, exclude []string) bool {
+	tags := make(map[string]bool, len(e.Tags))
+	for _, t := range e.Tags {
+		tags[t] = true
+	}
+	for _, t := range include {
+		if !tags[t] {
+			return false
+		}
+	}
+	for _, t := range exclude {
+		if tags[t] {
+			return false
+		}
+	}
+	return true
+}
+
+// LoadLock reads a lockfile (YAML or JSON, sigs.k8s.io/yaml handles both)
+// from path and makes its entries available to subsequent calls to Add.
+func (a *AssetStore) LoadLock(p string) error {
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return fmt.Errorf("error reading lockfile %q: %v", p, err)
+	}
+
+	lock := &LockFile{}
+	if err := yaml.Unmarshal(b, lock); err != nil {
+		return fmt.Errorf("error parsing lockfile %q: %v", p, err)
+	}
+
+	a.lock = lock
+	klog.Infof("loaded asset lockfile %q with %d entries", p, len(lock.Assets))
+	return nil
+}
+
+// AddResource records a new asset in the in-memory lockfile, to be written
+// out by a subsequent call to Save. Unlike Add, it always fetches the
+// asset (there is nothing to consult the lock against yet) and hashes it
+// with the requested algorithm, so the resulting entry is verifiable
+// regardless of what hash (if any) the mirror happens to expose via ETag.
+func (a *AssetStore) AddResource(urls []string, algorithm string, filename string, tags []string) error {
+	if len(urls) == 0 {
+		return fmt.Errorf("no urls were specified")
+	}
+
+	hashAlgorithm, err := hashing.HashAlgorithmFromString(algorithm)
+	if err != nil {
+		return fmt.Errorf("unknown hash algorithm %q: %v", algorithm, err)
+	}
+
+	primaryURL := urls[0]
+	localFile := path.Join(a.cacheDir, "lock-"+utils.SanitizeString(path.Base(primaryURL)))
+
+	var downloadErr error
+	for _, url := range urls {
+		client, clientErr := a.httpClientForURL(url)
+		if clientErr != nil {
+			downloadErr = clientErr
+			klog.Warningf("error building http client for %q: %v", url, downloadErr)
+			continue
+		}
+		_, downloadErr = downloadURLWithRetry(client, url, localFile, nil, a.options.Retry)
+		if downloadErr != nil {
+			klog.Warningf("error downloading url %q: %v", url, downloadErr)
+			continue
+		}
+		break
+	}
+	if downloadErr != nil {
+		return downloadErr
+	}
+
+	hash, err := hashAlgorithm.HashFile(localFile)
+	if err != nil {
+		return fmt.Errorf("error hashing %q with %q: %v", localFile, algorithm, err)
+	}
+
+	if a.lock == nil {
+		a.lock = &LockFile{Version: lockFileVersion}
+	}
+
+	a.lock.Assets = append(a.lock.Assets, &LockEntry{
+		URLs:      urls,
+		Algorithm: algorithm,
+		Digest:    hash.Hex(),
+		Filename:  filename,
+		Tags:      tags,
+	})
+
+	return nil
+}
+
+// Save writes the in-memory lockfile to path as YAML.
+func (a *AssetStore) Save(p string) error {
+	if a.lock == nil {
+		a.lock = &LockFile{Version: lockFileVersion}
+	}
+
+	b, err := yaml.Marshal(a.lock)
+	if err != nil {
+		return fmt.Errorf("error marshaling lockfile: %v", err)
+	}
+
+	if err := ioutil.WriteFile(p, b, 0644); err != nil {
+		return fmt.Errorf("error writing lockfile %q: %v", p, err)
+	}
+
+	return nil
+}
+
+// FindByTag returns the assets (already resolved via Add) whose source
+// lockfile entry carries every tag in include and none of the tags in
+// exclude. It replaces the ad-hoc regexp matching used by FindMatches for
+// install flows that are naturally described by tags (e.g. "cni",
+// "arch:amd64") rather than by asset filename pattern.
+func (a *AssetStore) FindByTag(include, exclude []string) map[string]Resource {
+	matches := make(map[string]Resource)
+
+	for _, entry := range a.lockEntriesByTag(include, exclude) {
+		for _, asset := range a.assets {
+			if asset.source == nil || asset.source.URL == "" {
+				continue
+			}
+			if !urlIn(asset.source.URL, entry.URLs) {
+				continue
+			}
+			matches[asset.Key] = &assetResource{Asset: asset}
+		}
+	}
+
+	return matches
+}
+
+func (a *AssetStore) lockEntriesByTag(include, exclude []string) []*LockEntry {
+	var matches []*LockEntry
+	if a.lock == nil {
+		return matches
+	}
+	for _, entry := range a.lock.Assets {
+		if entry.hasTags(include, exclude) {
+			matches = append(matches, entry)
+		}
+	}
+	return matches
+}
+
+func urlIn(url string, urls []string) bool {
+	for _, u := range urls {
+		if u == url {
+			return true
+		}
+	}
+	return false
+}
+
+// findLockEntry returns the first lockfile entry (if any) that lists one of
+// urls among its URLs, or whose filename equals the base name of one of
+// urls, so that Add can resolve the digest (and
+// mirror list) from the lockfile rather than hitting the network.
+func (a *AssetStore) findLockEntry(urls []string) *LockEntry {
+	if a.lock == nil {
+		return nil
+	}
+	for _, entry := range a.lock.Assets {
+		for _, url := range urls {
+			if urlIn(url, entry.URLs) || (entry.Filename != "" && path.Base(url) == entry.Filename) {
+				return entry
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fi
+
+import "testing"
+
+func TestLockEntryHasTags(t *testing.T) {
+	entry := &LockEntry{Tags: []string{"cni", "arch:amd64"}}
+
+	grid := []struct {
+		name    string
+		include []string
+		exclude []string
+		want    bool
+	}{
+		{name: "no filter matches", want: true},
+		{name: "matching include", include: []string{"cni"}, want: true},
+		{name: "matching multiple include", include: []string{"cni", "arch:amd64"}, want: true},
+		{name: "missing include", include: []string{"containerd"}, want: false},
+		{name: "partial include missing", include: []string{"cni", "containerd"}, want: false},
+		{name: "matching exclude", exclude: []string{"cni"}, want: false},
+		{name: "non-matching exclude", exclude: []string{"containerd"}, want: true},
+		{name: "include and exclude both satisfied", include: []string{"cni"}, exclude: []string{"containerd"}, want: true},
+	}
+
+	for _, g := range grid {
+		t.Run(g.name, func(t *testing.T) {
+			if got := entry.hasTags(g.include, g.exclude); got != g.want {
+				t.Errorf("hasTags(%v, %v) = %v, want %v", g.include, g.exclude, got, g.want)
+			}
+		})
+	}
+}
+
+func TestAssetStoreFindByTag(t *testing.T) {
+	a := NewAssetStore("/tmp/cache", DefaultAssetStoreOptions())
+	a.lock = &LockFile{
+		Assets: []*LockEntry{
+			{URLs: []string{"https://example.com/cni.tar.gz"}, Tags: []string{"cni"}},
+			{URLs: []string{"https://example.com/containerd.tar.gz"}, Tags: []string{"containerd"}},
+		},
+	}
+	a.assets = []*asset{
+		{Key: "cni.tar.gz", source: &Source{URL: "https://example.com/cni.tar.gz"}},
+		{Key: "containerd.tar.gz", source: &Source{URL: "https://example.com/containerd.tar.gz"}},
+	}
+
+	matches := a.FindByTag([]string{"cni"}, nil)
+	if len(matches) != 1 {
+		t.Fatalf("FindByTag([cni]) returned %d matches, want 1", len(matches))
+	}
+	if _, ok := matches["cni.tar.gz"]; !ok {
+		t.Errorf("FindByTag([cni]) did not include cni.tar.gz, got %v", matches)
+	}
+}
@@ -0,0 +1,206 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fi
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+)
+
+// AssetRepository configures how the AssetStore talks to a private asset
+// mirror: a self-hosted S3-compatible store, an Artifactory instance, or an
+// mTLS-protected endpoint. Repositories are matched against asset URLs by
+// URLPrefix, so a cluster spec can point to
+// "https://artifacts.corp.example/kops/..." and have credentials applied
+// automatically.
+type AssetRepository struct {
+	// URLPrefix selects which asset URLs this repository config applies to.
+	URLPrefix string `json:"urlPrefix"`
+
+	CAFile             string `json:"caFile,omitempty"`
+	CertFile           string `json:"certFile,omitempty"`
+	KeyFile            string `json:"keyFile,omitempty"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify,omitempty"`
+
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+
+	BearerToken string `json:"bearerToken,omitempty"`
+	// BearerTokenFile, if set, is re-read on every request so that a
+	// refreshed token (e.g. written by a sidecar) takes effect without
+	// restarting the process.
+	BearerTokenFile string `json:"bearerTokenFile,omitempty"`
+}
+
+// assetRepositoriesFile is the schema of ~/.kops/assets.yaml.
+type assetRepositoriesFile struct {
+	Repositories []*AssetRepository `json:"repositories"`
+}
+
+// authTransport applies basic auth / bearer token credentials to each
+// request before delegating to the wrapped RoundTripper.
+type authTransport struct {
+	base http.RoundTripper
+	repo *AssetRepository
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+
+	if t.repo.Username != "" || t.repo.Password != "" {
+		req.SetBasicAuth(t.repo.Username, t.repo.Password)
+	}
+
+	token := t.repo.BearerToken
+	if t.repo.BearerTokenFile != "" {
+		b, err := ioutil.ReadFile(t.repo.BearerTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading bearer token file %q: %v", t.repo.BearerTokenFile, err)
+		}
+		token = strings.TrimSpace(string(b))
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	return t.base.RoundTrip(req)
+}
+
+// SetRepositories registers the repositories that addURLs/hashFromHTTPHeader
+// should authenticate against, keyed by URL prefix. Callers are expected to
+// populate this from spec.assets.repositories on the cluster spec, merged
+// with LoadAssetRepositoriesFile, before calling Add.
+
+func (a *AssetStore) SetRepositories(repositories []*AssetRepository) {
+	a.repositories = repositories
+	a.httpClients = nil
+}
+
+// LoadAssetRepositoriesFile reads the asset repository config at p
+// (typically DefaultAssetRepositoriesPath, ~/.kops/assets.yaml) and
+// registers its repositories. A missing file is not an error.
+func (a *AssetStore) LoadAssetRepositoriesFile(p string) error {
+	b, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading asset repositories file %q: %v", p, err)
+	}
+
+	parsed := &assetRepositoriesFile{}
+	if err := yaml.Unmarshal(b, parsed); err != nil {
+		return fmt.Errorf("error parsing asset repositories file %q: %v", p, err)
+	}
+
+	a.SetRepositories(append(a.repositories, parsed.Repositories...))
+	klog.V(2).Infof("loaded %d asset repositories from %q", len(parsed.Repositories), p)
+	return nil
+}
+
+// DefaultAssetRepositoriesPath returns the default location of the
+// user-level asset repository config, ~/.kops/assets.yaml.
+func DefaultAssetRepositoriesPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("error determining home directory: %v", err)
+	}
+	return filepath.Join(home, ".kops", "assets.yaml"), nil
+}
+
+// repositoryForURL returns the most specific (longest URLPrefix) repository
+// configured for url, or nil if none matches.
+func (a *AssetStore) repositoryForURL(url string) *AssetRepository {
+	var best *AssetRepository
+	for _, repo := range a.repositories {
+		if !strings.HasPrefix(url, repo.URLPrefix) {
+			continue
+		}
+		if best == nil || len(repo.URLPrefix) > len(best.URLPrefix) {
+			best = repo
+		}
+	}
+	return best
+}
+
+// httpClientForURL returns the (cached) *http.Client to use for url,
+// built from the matching AssetRepository's TLS and auth settings, or
+// http.DefaultClient if no repository matches.
+func (a *AssetStore) httpClientForURL(url string) (*http.Client, error) {
+	repo := a.repositoryForURL(url)
+	if repo == nil {
+		return http.DefaultClient, nil
+	}
+
+	if a.httpClients == nil {
+		a.httpClients = make(map[string]*http.Client)
+	}
+	if client, ok := a.httpClients[repo.URLPrefix]; ok {
+		return client, nil
+	}
+
+	client, err := buildHTTPClient(repo)
+	if err != nil {
+		return nil, fmt.Errorf("error building http client for repository %q: %v", repo.URLPrefix, err)
+	}
+
+	a.httpClients[repo.URLPrefix] = client
+	return client, nil
+}
+
+func buildHTTPClient(repo *AssetRepository) (*http.Client, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: repo.InsecureSkipVerify,
+	}
+
+	if repo.CAFile != "" {
+		caCert, err := ioutil.ReadFile(repo.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading CA file %q: %v", repo.CAFile, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no certificates found in CA file %q", repo.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if repo.CertFile != "" || repo.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(repo.CertFile, repo.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading client certificate %q/%q: %v", repo.CertFile, repo.KeyFile, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	transport := &http.Transport{
+		TLSClientConfig: tlsConfig,
+	}
+
+	return &http.Client{
+		Transport: &authTransport{base: transport, repo: repo},
+	}, nil
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fi
+
+import (
+	"net/http"
+	"testing"
+)
+
+// fakeRoundTripper records the last request it saw and returns a canned
+// response, so tests can assert what authTransport did to the request
+// without making a real network call.
+type fakeRoundTripper struct {
+	lastRequest *http.Request
+}
+
+func (f *fakeRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	f.lastRequest = req
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+}
+
+func TestAuthTransportBasicAuth(t *testing.T) {
+	base := &fakeRoundTripper{}
+	transport := &authTransport{
+		base: base,
+		repo: &AssetRepository{Username: "alice", Password: "hunter2"},
+	}
+
+	req, _ := http.NewRequest("GET", "https://mirror.example/asset", nil)
+	if _, err := transport.RoundTrip(req); err != nil {
+		t.Fatalf("RoundTrip returned unexpected error: %v", err)
+	}
+
+	user, pass, ok := base.lastRequest.BasicAuth()
+	if !ok {
+		t.Fatal("expected basic auth to be set on outgoing request")
+	}
+	if user != "alice" || pass != "hunter2" {
+		t.Errorf("BasicAuth() = (%q, %q), want (alice, hunter2)", user, pass)
+	}
+}
+
+func TestAuthTransportBearerToken(t *testing.T) {
+	base := &fakeRoundTripper{}
+	transport := &authTransport{
+		base: base,
+		repo: &AssetRepository{BearerToken: "sometoken"},
+	}
+
+	req, _ := http.NewRequest("GET", "https://mirror.example/asset", nil)
+	if _, err := transport.RoundTrip(req); err != nil {
+		t.Fatalf("RoundTrip returned unexpected error: %v", err)
+	}
+
+	if got := base.lastRequest.Header.Get("Authorization"); got != "Bearer sometoken" {
+		t.Errorf("Authorization header = %q, want %q", got, "Bearer sometoken")
+	}
+}
+
+func TestAuthTransportDoesNotMutateOriginalRequest(t *testing.T) {
+	base := &fakeRoundTripper{}
+	transport := &authTransport{
+		base: base,
+		repo: &AssetRepository{Username: "alice", Password: "hunter2"},
+	}
+
+	req, _ := http.NewRequest("GET", "https://mirror.example/asset", nil)
+	if _, err := transport.RoundTrip(req); err != nil {
+		t.Fatalf("RoundTrip returned unexpected error: %v", err)
+	}
+
+	if _, _, ok := req.BasicAuth(); ok {
+		t.Error("original request was mutated with basic auth credentials")
+	}
+}
+
+func TestRepositoryForURLPicksLongestPrefix(t *testing.T) {
+	a := NewAssetStore("/tmp/cache", DefaultAssetStoreOptions())
+	general := &AssetRepository{URLPrefix: "https://artifacts.corp.example/"}
+	specific := &AssetRepository{URLPrefix: "https://artifacts.corp.example/kops/"}
+	a.SetRepositories([]*AssetRepository{general, specific})
+
+	got := a.repositoryForURL("https://artifacts.corp.example/kops/kubelet")
+	if got != specific {
+		t.Errorf("repositoryForURL() picked %+v, want the more specific prefix %+v", got, specific)
+	}
+
+	got = a.repositoryForURL("https://artifacts.corp.example/other/asset")
+	if got != general {
+		t.Errorf("repositoryForURL() picked %+v, want the general prefix %+v", got, general)
+	}
+
+	got = a.repositoryForURL("https://unrelated.example/asset")
+	if got != nil {
+		t.Errorf("repositoryForURL() = %+v, want nil for an unconfigured host", got)
+	}
+}
+
+func TestBuildHTTPClientInsecureSkipVerify(t *testing.T) {
+	client, err := buildHTTPClient(&AssetRepository{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("buildHTTPClient returned unexpected error: %v", err)
+	}
+	transport, ok := client.Transport.(*authTransport)
+	if !ok {
+		t.Fatalf("client.Transport is %T, want *authTransport", client.Transport)
+	}
+	httpTransport, ok := transport.base.(*http.Transport)
+	if !ok {
+		t.Fatalf("transport.base is %T, want *http.Transport", transport.base)
+	}
+	if !httpTransport.TLSClientConfig.InsecureSkipVerify {
+		t.Error("expected InsecureSkipVerify to be threaded through to the TLS config")
+	}
+}
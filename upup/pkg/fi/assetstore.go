@@ -21,7 +21,6 @@ import (
 	"io"
 	"net/http"
 	"os"
-	"os/exec"
 	"path"
 	"path/filepath"
 	"regexp"
@@ -32,6 +31,7 @@ import (
 	"k8s.io/klog/v2"
 	"k8s.io/kops/upup/pkg/fi/utils"
 	"k8s.io/kops/util/pkg/hashing"
+	"k8s.io/kops/util/pkg/httputil"
 )
 
 type asset struct {
@@ -46,6 +46,17 @@ type Source struct {
 	URL                string
 	Hash               *hashing.Hash
 	ExtractFromArchive string
+
+	// ArchiveFormat records the archive container format of the asset at
+	// URL, once it has been extracted as an archive; the Source of each
+	// extracted member reaches it through Parent. This lets downstream
+	// tasks distinguish a zip-sourced asset from a tar-sourced one (e.g.
+	// when deciding whether to preserve unix file modes).
+	ArchiveFormat ArchiveFormat
+
+	// OCIRef holds the original oci:// reference for assets resolved from
+	// a container registry, e.g. "registry/repo:tag@sha256:...".
+	OCIRef string
 }
 
 // Key builds a unique key for this source
@@ -91,11 +102,43 @@ func (r *assetResource) GetSource() *Source {
 type AssetStore struct {
 	cacheDir string
 	assets   []*asset
+	options  AssetStoreOptions
+
+	// lock holds the lockfile loaded via LoadLock (if any), consulted by
+	// Add before falling back to network hashing.
+	lock *LockFile
+
+	// repositories holds the private mirror configs registered via
+	// SetRepositories/LoadAssetRepositoriesFile, keyed by URL prefix.
+	repositories []*AssetRepository
+	// httpClients caches the *http.Client built for each repository, so
+	// TLS configuration is only parsed once.
+	httpClients map[string]*http.Client
+}
+
+// AssetStoreOptions configures the retry behavior used when an AssetStore
+// talks to HTTP(S) mirrors. The zero value makes a single attempt per
+// request with no retries; callers should start from
+// DefaultAssetStoreOptions to get retries.
+type AssetStoreOptions struct {
+	// Retry controls the backoff used for HEAD/GET requests against asset
+	// mirrors, so that a transient 5xx or network blip doesn't immediately
+	// exhaust the mirror list passed to addURLs.
+	Retry httputil.RetryConfig
 }
 
-func NewAssetStore(cacheDir string) *AssetStore {
+// DefaultAssetStoreOptions returns the retry policy used unless the caller
+// (typically kops CLI flags, for slow or air-gapped mirrors) overrides it.
+func DefaultAssetStoreOptions() AssetStoreOptions {
+	return AssetStoreOptions{
+		Retry: httputil.DefaultRetryConfig(),
+	}
+}
+
+func NewAssetStore(cacheDir string, options AssetStoreOptions) *AssetStore {
 	a := &AssetStore{
 		cacheDir: cacheDir,
+		options:  options,
 	}
 	return a
 }
@@ -174,9 +217,15 @@ func (a *AssetStore) AddForTest(id string, path string, content string) {
 	})
 }
 
-func hashFromHTTPHeader(url string) (*hashing.Hash, error) {
+func (a *AssetStore) hashFromHTTPHeader(url string) (*hashing.Hash, error) {
 	klog.Infof("Doing HTTP HEAD on %q", url)
-	response, err := http.Head(url)
+	client, err := a.httpClientForURL(url)
+	if err != nil {
+		return nil, err
+	}
+	response, err := httputil.Do(a.options.Retry, func() (*http.Response, error) {
+		return client.Head(url)
+	})
 	if err != nil {
 		return nil, fmt.Errorf("error doing HEAD on %q: %v", url, err)
 	}
@@ -198,8 +247,23 @@ func hashFromHTTPHeader(url string) (*hashing.Hash, error) {
 
 // Add an asset into the store, in one of the recognized formats (see Assets in types package)
 func (a *AssetStore) Add(id string) error {
+	if strings.HasPrefix(id, "oci://") {
+		ref, err := parseOCIRef(id)
+		if err != nil {
+			return err
+		}
+		return a.addOCIArtifact(id, ref)
+	}
 	if strings.HasPrefix(id, "http://") || strings.HasPrefix(id, "https://") {
-		return a.addURLs(strings.Split(id, ","), nil)
+		urls := strings.Split(id, ",")
+		if entry := a.findLockEntry(urls); entry != nil {
+			hash, err := entry.hash()
+			if err != nil {
+				return err
+			}
+			return a.addURLs(entry.URLs, hash, entry.Filename, entry.Mode)
+		}
+		return a.addURLs(urls, nil, "", "")
 	}
 	i := strings.Index(id, "@http://")
 	if i == -1 {
@@ -211,13 +275,20 @@ func (a *AssetStore) Add(id string) error {
 		if err != nil {
 			return err
 		}
-		return a.addURLs(urls, hash)
+		return a.addURLs(urls, hash, "", "")
 	}
 	// TODO: local files!
 	return fmt.Errorf("unknown asset format: %q", id)
 }
 
-func (a *AssetStore) addURLs(urls []string, hash *hashing.Hash) error {
+// addURLs downloads and registers the asset at urls (with the first url
+// treated as the primary, and the rest as mirrors to fall back to), verified
+// against hash (or, if hash is nil, a hash discovered via HTTP HEAD). filename
+// and mode, when non-empty, come from the asset's lockfile entry: filename
+// overrides the cache filename normally derived from the primary URL, and
+// mode (an octal permission string, e.g. "0755") is applied to the
+// downloaded file once it lands in the cache.
+func (a *AssetStore) addURLs(urls []string, hash *hashing.Hash, filename string, mode string) error {
 	if len(urls) == 0 {
 		return fmt.Errorf("no urls were specified")
 	}
@@ -225,7 +296,7 @@ func (a *AssetStore) addURLs(urls []string, hash *hashing.Hash) error {
 	var err error
 	if hash == nil {
 		for _, url := range urls {
-			hash, err = hashFromHTTPHeader(url)
+			hash, err = a.hashFromHTTPHeader(url)
 			if err != nil {
 				klog.Warningf("unable to get hash from %q: %v", url, err)
 				continue
@@ -241,10 +312,20 @@ func (a *AssetStore) addURLs(urls []string, hash *hashing.Hash) error {
 	// We assume the first url is the "main" url, and download to the base of that _name_, wherever we get it from
 	primaryURL := urls[0]
 	key := path.Base(primaryURL)
-	localFile := path.Join(a.cacheDir, hash.String()+"_"+utils.SanitizeString(key))
+	localFileName := key
+	if filename != "" {
+		localFileName = filename
+	}
+	localFile := path.Join(a.cacheDir, hash.String()+"_"+utils.SanitizeString(localFileName))
 
 	for _, url := range urls {
-		_, err = DownloadURL(url, localFile, hash)
+		client, clientErr := a.httpClientForURL(url)
+		if clientErr != nil {
+			err = clientErr
+			klog.Warningf("error building http client for %q: %v", url, err)
+			continue
+		}
+		_, err = downloadURLWithRetry(client, url, localFile, hash, a.options.Retry)
 		if err != nil {
 			klog.Warningf("error downloading url %q: %v", url, err)
 			continue
@@ -256,6 +337,16 @@ func (a *AssetStore) addURLs(urls []string, hash *hashing.Hash) error {
 		return err
 	}
 
+	if mode != "" {
+		parsed, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil {
+			return fmt.Errorf("invalid file mode %q for %q: %v", mode, primaryURL, err)
+		}
+		if err := os.Chmod(localFile, os.FileMode(parsed)); err != nil {
+			return fmt.Errorf("error setting mode %q on %q: %v", mode, localFile, err)
+		}
+	}
+
 	assetPath := primaryURL
 	r := NewFileResource(localFile)
 
@@ -270,20 +361,27 @@ func (a *AssetStore) addURLs(urls []string, hash *hashing.Hash) error {
 	klog.V(2).Infof("added asset %q for %q", asset.Key, asset.resource)
 	a.assets = append(a.assets, asset)
 
-	// normalize filename suffix
-	file := strings.ToLower(assetPath)
-	// pickup both tar.gz and tgz files
-	if strings.HasSuffix(file, ".tar.gz") || strings.HasSuffix(file, ".tgz") {
-		err = a.addArchive(source, localFile)
-		if err != nil {
-			return err
+	archiveFormat, err := detectArchiveFormat(primaryURL, localFile)
+	if err == nil && archiveFormat != ArchiveFormatUnknown {
+		if err := a.addArchive(source, archiveFormat, localFile); err != nil {
+			if archiveFormatFromSuffix(primaryURL) != ArchiveFormatUnknown {
+				return err
+			}
+			// We only guessed the format by sniffing the file's magic
+			// bytes (the URL itself had no recognized archive suffix), and
+			// a plain asset's bytes can coincidentally look like an
+			// archive header (e.g. a lone gzip-compressed binary). Treat
+			// it as a plain asset rather than failing the download.
+			klog.Warningf("asset %q looked like a %s archive but did not extract as one; treating it as a plain asset: %v", primaryURL, archiveFormat, err)
+			return nil
 		}
+		source.ArchiveFormat = archiveFormat
 	}
 
 	return nil
 }
 
-func (a *AssetStore) addArchive(archiveSource *Source, archiveFile string) error {
+func (a *AssetStore) addArchive(archiveSource *Source, format ArchiveFormat, archiveFile string) error {
 	extracted := path.Join(a.cacheDir, "extracted/"+path.Base(archiveFile))
 
 	if _, err := os.Stat(extracted); os.IsNotExist(err) {
@@ -295,12 +393,9 @@ func (a *AssetStore) addArchive(archiveSource *Source, archiveFile string) error
 			return fmt.Errorf("error creating directories %q: %v", path.Dir(extractedTemp), err)
 		}
 
-		args := []string{"tar", "zxf", archiveFile, "-C", extractedTemp}
-		klog.Infof("running extract command %s", args)
-		cmd := exec.Command(args[0], args[1:]...)
-		output, err := cmd.CombinedOutput()
-		if err != nil {
-			return fmt.Errorf("error expanding asset file %q %v: %s", archiveFile, err, string(output))
+		klog.Infof("extracting %s archive %q", format, archiveFile)
+		if err := extractArchive(format, archiveFile, extractedTemp); err != nil {
+			return fmt.Errorf("error expanding asset file %q: %v", archiveFile, err)
 		}
 
 		if err := os.Rename(extractedTemp, extracted); err != nil {
@@ -0,0 +1,97 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fi
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"k8s.io/kops/util/pkg/hashing"
+)
+
+// TestAddURLsTreatsSniffedArchiveMismatchAsPlainAsset covers a plain asset
+// (no recognized archive suffix) whose raw bytes happen to start with a
+// gzip magic number: detectArchiveFormat will guess tar.gz by sniffing, but
+// the content isn't actually a tar stream. addURLs must not fail the
+// download over a guess that didn't pan out.
+func TestAddURLsTreatsSniffedArchiveMismatchAsPlainAsset(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write([]byte("not actually a tar stream")); err != nil {
+		t.Fatalf("error writing test content: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("error closing gzip writer: %v", err)
+	}
+	content := buf.Bytes()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(content)
+	}))
+	defer server.Close()
+
+	hash, err := hashing.HashAlgorithmSHA256.Hash(bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("error hashing test content: %v", err)
+	}
+
+	a := NewAssetStore(t.TempDir(), DefaultAssetStoreOptions())
+	if err := a.addURLs([]string{server.URL + "/some-binary"}, hash, "", ""); err != nil {
+		t.Fatalf("addURLs returned unexpected error for a sniffed-but-unparseable archive: %v", err)
+	}
+
+	if len(a.assets) != 1 {
+		t.Fatalf("expected exactly 1 asset (the plain file, no extracted archive members), got %d", len(a.assets))
+	}
+}
+
+// TestAddURLsHonorsLockEntryFilenameAndMode covers a lockfile entry that
+// specifies a filename override and a file mode: both must actually take
+// effect on the cached file, not just be recorded and ignored.
+func TestAddURLsHonorsLockEntryFilenameAndMode(t *testing.T) {
+	content := "binary content"
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(content))
+	}))
+	defer server.Close()
+
+	hash, err := hashing.HashAlgorithmSHA256.Hash(bytes.NewReader([]byte(content)))
+	if err != nil {
+		t.Fatalf("error hashing test content: %v", err)
+	}
+
+	cacheDir := t.TempDir()
+	a := NewAssetStore(cacheDir, DefaultAssetStoreOptions())
+	if err := a.addURLs([]string{server.URL + "/download"}, hash, "renamed-binary", "0640"); err != nil {
+		t.Fatalf("addURLs returned unexpected error: %v", err)
+	}
+
+	wantPath := filepath.Join(cacheDir, hash.String()+"_renamed-binary")
+	info, err := os.Stat(wantPath)
+	if err != nil {
+		t.Fatalf("expected cached file at %q (from LockEntry.Filename): %v", wantPath, err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("cached file mode = %v, want 0640 (from LockEntry.Mode)", info.Mode().Perm())
+	}
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fi
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"k8s.io/klog/v2"
+	"k8s.io/kops/util/pkg/hashing"
+	"k8s.io/kops/util/pkg/httputil"
+)
+
+// DownloadURL downloads url to dest, verifying the result against hash (if
+// provided). If a partial download from an earlier call is left in dest's
+// temporary file (dest + ".tmp-download"), it is resumed with a Range
+// request rather than restarted from scratch.
+func DownloadURL(url string, dest string, hash *hashing.Hash) (*hashing.Hash, error) {
+	return downloadURLWithRetry(http.DefaultClient, url, dest, hash, httputil.DefaultRetryConfig())
+}
+
+func downloadURLWithRetry(client *http.Client, url string, dest string, hash *hashing.Hash, retryConfig httputil.RetryConfig) (*hashing.Hash, error) {
+	if existing, err := hashAndVerify(dest, hash); err == nil && existing != nil {
+		klog.V(2).Infof("found existing file %q matching expected hash; skipping download", dest)
+		return existing, nil
+	}
+
+	tmpFile := dest + ".tmp-download"
+
+	var resumeFrom int64
+	if fi, err := os.Stat(tmpFile); err == nil {
+		resumeFrom = fi.Size()
+	}
+
+	resp, err := httputil.Do(retryConfig, func() (*http.Response, error) {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			return nil, err
+		}
+		if resumeFrom > 0 {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", resumeFrom))
+		}
+		return client.Do(req)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error downloading %q: %v", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return nil, fmt.Errorf("error downloading %q: unexpected status code %d", url, resp.StatusCode)
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE
+	if resp.StatusCode == http.StatusPartialContent {
+		flags |= os.O_APPEND
+	} else {
+		flags |= os.O_TRUNC
+		resumeFrom = 0
+	}
+
+	out, err := os.OpenFile(tmpFile, flags, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error creating file %q: %v", tmpFile, err)
+	}
+
+	if _, err := io.Copy(out, resp.Body); err != nil {
+		out.Close()
+		return nil, fmt.Errorf("error writing file %q: %v", tmpFile, err)
+	}
+	if err := out.Close(); err != nil {
+		return nil, fmt.Errorf("error closing file %q: %v", tmpFile, err)
+	}
+
+	actualHash, err := verifyDownload(tmpFile, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Rename(tmpFile, dest); err != nil {
+		return nil, fmt.Errorf("error renaming %q -> %q: %v", tmpFile, dest, err)
+	}
+
+	return actualHash, nil
+}
+
+// hashAndVerify hashes the file at path with expected's algorithm and
+// returns that hash if it matches expected. It returns (nil, nil) without
+// an error if path does not exist, if expected is nil, or if the file's
+// hash does not match.
+func hashAndVerify(path string, expected *hashing.Hash) (*hashing.Hash, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if expected == nil {
+		return nil, nil
+	}
+
+	actual, err := expected.Algorithm.HashFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error hashing file %q: %v", path, err)
+	}
+	if !actual.Equal(expected) {
+		return nil, nil
+	}
+	return actual, nil
+}
+
+// verifyDownload hashes the file at path and errors if it does not match
+// expected. Unlike hashAndVerify (which treats a mismatch as "no reusable
+// file found"), a mismatch here means we just downloaded the wrong bytes,
+// which must never be treated as success. expected == nil skips
+// verification and simply returns the computed SHA-256 hash.
+func verifyDownload(path string, expected *hashing.Hash) (*hashing.Hash, error) {
+	if expected == nil {
+		return hashing.HashAlgorithmSHA256.HashFile(path)
+	}
+
+	actual, err := expected.Algorithm.HashFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error hashing file %q: %v", path, err)
+	}
+	if !actual.Equal(expected) {
+		return nil, fmt.Errorf("error validating download %q: hash mismatch (expected %s, got %s)", path, expected.Hex(), actual.Hex())
+	}
+	return actual, nil
+}
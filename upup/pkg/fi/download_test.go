@@ -0,0 +1,62 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"k8s.io/kops/util/pkg/hashing"
+)
+
+func TestDownloadURLRejectsHashMismatch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("the actual content"))
+	}))
+	defer server.Close()
+
+	wrongHash, err := hashing.HashAlgorithmSHA256.FromString("0000000000000000000000000000000000000000000000000000000000000000")
+	if err != nil {
+		t.Fatalf("error building test hash: %v", err)
+	}
+
+	dest := filepath.Join(t.TempDir(), "asset")
+	if _, err := DownloadURL(server.URL, dest, wrongHash); err == nil {
+		t.Fatal("DownloadURL with a mismatched hash returned nil error, want an error")
+	}
+}
+
+func TestDownloadURLAcceptsMatchingHash(t *testing.T) {
+	content := "the actual content"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(content))
+	}))
+	defer server.Close()
+
+	hash, err := hashing.HashAlgorithmSHA256.Hash(strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("error hashing test content: %v", err)
+	}
+
+	dest := filepath.Join(t.TempDir(), "asset")
+	if _, err := DownloadURL(server.URL, dest, hash); err != nil {
+		t.Fatalf("DownloadURL with a matching hash returned unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,361 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fi
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"k8s.io/klog/v2"
+	"k8s.io/kops/util/pkg/hashing"
+)
+
+// Transformer is a single stage of a Resource processing pipeline: it reads
+// the bytes produced by the previous stage and returns the bytes to feed
+// into the next one, or into the terminal Open() call. Modeled on Hugo's
+// resource "Piper", so that tasks can compose asset processing
+// declaratively instead of juggling NewStringResource/NewFileResource by
+// hand.
+type Transformer interface {
+	// Name identifies the transformer for cache-key purposes; it must be
+	// stable across runs for a given configuration of the transformer.
+	Name() string
+	Transform(io.Reader) (io.Reader, error)
+}
+
+// sideEffecter is implemented by transformers whose Transform has an
+// external side effect (beyond the bytes it returns) that must still
+// happen on a pipeline cache hit, since a cache hit otherwise skips calling
+// Transform entirely. SideEffect receives the same (pre-transform) input
+// bytes Transform would have seen.
+type sideEffecter interface {
+	SideEffect(input []byte) error
+}
+
+// pipelineResource is the head of a lazily-evaluated transformation
+// pipeline: each Pipe call appends a stage, and nothing runs until Open is
+// called (directly, or via the next stage's Open).
+type pipelineResource struct {
+	base        Resource
+	cacheDir    string
+	transformer Transformer
+	signature   string
+}
+
+var _ Resource = &pipelineResource{}
+
+// Pipe returns a Resource equivalent to r with the given transformers
+// applied in order. Each stage memoizes its output into cacheDir, keyed by
+// a signature of the input content hash and the pipeline so far, so that
+// repeat runs (e.g. re-running `kops update cluster`) skip redoing
+// expensive work like template rendering or gzip compression.
+func Pipe(r Resource, cacheDir string, transformers ...Transformer) Resource {
+	current := r
+	signature := ""
+	for _, t := range transformers {
+		signature = signature + "/" + t.Name()
+		current = &pipelineResource{
+			base:        current,
+			cacheDir:    cacheDir,
+			transformer: t,
+			signature:   signature,
+		}
+	}
+	return current
+}
+
+func (p *pipelineResource) Open() (io.Reader, error) {
+	// base.Open() is called exactly once: some Resource implementations
+	// (a one-shot stream or subprocess pipe) cannot be opened twice, and
+	// re-opening here would also double the number of Open calls made on
+	// the resource below us for every additional pipeline stage.
+	in, err := p.base.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	input, err := ioutil.ReadAll(in)
+	if err != nil {
+		return nil, fmt.Errorf("error reading pipeline input: %v", err)
+	}
+
+	inputHash, err := hashing.HashAlgorithmSHA256.Hash(bytes.NewReader(input))
+	if err != nil {
+		return nil, fmt.Errorf("error hashing pipeline input: %v", err)
+	}
+
+	cacheKey := path.Join(p.cacheDir, "pipeline", inputHash.Hex()+strings.ReplaceAll(p.signature, "/", "_"))
+	if b, err := ioutil.ReadFile(cacheKey); err == nil {
+		klog.V(2).Infof("pipeline cache hit for %s", p.signature)
+		// A cache hit skips re-running Transform, but a transformer like
+		// Fingerprint has a side effect (writing a companion file) that a
+		// fresh checkout reusing a pre-warmed cacheDir would otherwise
+		// never see. Replay that side effect even though the transformed
+		// bytes themselves don't need recomputing.
+		if se, ok := p.transformer.(sideEffecter); ok {
+			if err := se.SideEffect(input); err != nil {
+				return nil, fmt.Errorf("error applying side effect of transformer %q: %v", p.transformer.Name(), err)
+			}
+		}
+		return bytes.NewReader(b), nil
+	}
+
+	out, err := p.transformer.Transform(bytes.NewReader(input))
+	if err != nil {
+		return nil, fmt.Errorf("error running transformer %q: %v", p.transformer.Name(), err)
+	}
+
+	b, err := ioutil.ReadAll(out)
+	if err != nil {
+		return nil, fmt.Errorf("error reading transformer %q output: %v", p.transformer.Name(), err)
+	}
+
+	if err := os.MkdirAll(path.Dir(cacheKey), 0755); err == nil {
+		_ = ioutil.WriteFile(cacheKey, b, 0644)
+	}
+
+	return bytes.NewReader(b), nil
+}
+
+// gzipTransformer compresses its input with gzip.
+type gzipTransformer struct{}
+
+func Gzip() Transformer { return &gzipTransformer{} }
+
+func (t *gzipTransformer) Name() string { return "gzip" }
+
+func (t *gzipTransformer) Transform(r io.Reader) (io.Reader, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := io.Copy(gz, r); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return &buf, nil
+}
+
+// gunzipTransformer decompresses a gzip-compressed input.
+type gunzipTransformer struct{}
+
+func Gunzip() Transformer { return &gunzipTransformer{} }
+
+func (t *gunzipTransformer) Name() string { return "gunzip" }
+
+func (t *gunzipTransformer) Transform(r io.Reader) (io.Reader, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, gz); err != nil {
+		return nil, err
+	}
+	return &buf, nil
+}
+
+// extractMemberTransformer replaces a tar stream with the contents of a
+// single named member.
+type extractMemberTransformer struct {
+	memberPath string
+}
+
+// ExtractMember extracts a single file from a tar archive stream.
+func ExtractMember(memberPath string) Transformer {
+	return &extractMemberTransformer{memberPath: memberPath}
+}
+
+func (t *extractMemberTransformer) Name() string { return "extract-member:" + t.memberPath }
+
+func (t *extractMemberTransformer) Transform(r io.Reader) (io.Reader, error) {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("member %q not found in archive", t.memberPath)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Name == t.memberPath {
+			var buf bytes.Buffer
+			if _, err := io.Copy(&buf, io.LimitReader(tr, maxExtractedFileSize)); err != nil {
+				return nil, err
+			}
+			return &buf, nil
+		}
+	}
+}
+
+// templateTransformer renders its input as a kops template, using the
+// provided render function (typically backed by the existing kops
+// template context, e.g. text/template.Execute against a TemplateFunctions).
+type templateTransformer struct {
+	name   string
+	render func(templateBody string) (string, error)
+}
+
+// Template renders the input through render, typically a closure over the
+// kops template context already used by nodeup cloud-init generation.
+func Template(name string, render func(templateBody string) (string, error)) Transformer {
+	return &templateTransformer{name: name, render: render}
+}
+
+func (t *templateTransformer) Name() string { return "template:" + t.name }
+
+func (t *templateTransformer) Transform(r io.Reader) (io.Reader, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	rendered, err := t.render(string(b))
+	if err != nil {
+		return nil, fmt.Errorf("error rendering template %q: %v", t.name, err)
+	}
+	return strings.NewReader(rendered), nil
+}
+
+// base64Transformer base64-encodes its input, optionally gzipping first -
+// the common shape needed for cloud-init user-data payloads.
+type base64Transformer struct {
+	gzipFirst bool
+}
+
+// Base64 returns a transformer that base64-encodes its input.
+func Base64() Transformer { return &base64Transformer{} }
+
+// GzipBase64 returns a transformer that gzips then base64-encodes its
+// input, the encoding cloud-init expects for compressed user-data.
+func GzipBase64() Transformer { return &base64Transformer{gzipFirst: true} }
+
+func (t *base64Transformer) Name() string {
+	if t.gzipFirst {
+		return "gzip-base64"
+	}
+	return "base64"
+}
+
+func (t *base64Transformer) Transform(r io.Reader) (io.Reader, error) {
+	if t.gzipFirst {
+		gzipped, err := (&gzipTransformer{}).Transform(r)
+		if err != nil {
+			return nil, err
+		}
+		r = gzipped
+	}
+
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return strings.NewReader(base64.StdEncoding.EncodeToString(b)), nil
+}
+
+// fingerprintTransformer passes its input through unchanged, but also
+// writes the input's hash to a companion AssetPath, so a caller can
+// reference "$content.sha256" alongside the rendered asset.
+type fingerprintTransformer struct {
+	algorithm hashing.HashAlgorithm
+	writePath string
+}
+
+// Fingerprint returns a transformer that is transparent to the pipeline
+// content, but as a side effect writes the hex digest of that content
+// (using algorithm) to writePath.
+func Fingerprint(algorithm hashing.HashAlgorithm, writePath string) Transformer {
+	return &fingerprintTransformer{algorithm: algorithm, writePath: writePath}
+}
+
+func (t *fingerprintTransformer) Name() string { return "fingerprint:" + t.writePath }
+
+func (t *fingerprintTransformer) Transform(r io.Reader) (io.Reader, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := t.SideEffect(b); err != nil {
+		return nil, err
+	}
+
+	return bytes.NewReader(b), nil
+}
+
+var _ sideEffecter = &fingerprintTransformer{}
+
+// SideEffect writes the hex digest of input to writePath, so that it also
+// runs on a pipeline cache hit, when Transform itself is never called.
+func (t *fingerprintTransformer) SideEffect(input []byte) error {
+	hash, err := t.algorithm.Hash(bytes.NewReader(input))
+	if err != nil {
+		return fmt.Errorf("error fingerprinting pipeline content: %v", err)
+	}
+
+	if err := ioutil.WriteFile(t.writePath, []byte(hash.Hex()), 0644); err != nil {
+		return fmt.Errorf("error writing fingerprint to %q: %v", t.writePath, err)
+	}
+
+	return nil
+}
+
+// envsubstTransformer performs ${VAR} / $VAR substitution against a fixed
+// set of variables, the same shape as the Unix envsubst utility.
+type envsubstTransformer struct {
+	vars map[string]string
+}
+
+// Envsubst returns a transformer that replaces ${VAR} and $VAR references
+// in the input with values from vars.
+func Envsubst(vars map[string]string) Transformer {
+	return &envsubstTransformer{vars: vars}
+}
+
+func (t *envsubstTransformer) Name() string { return "envsubst" }
+
+func (t *envsubstTransformer) Transform(r io.Reader) (io.Reader, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	ref := regexp.MustCompile(`\$\{(\w+)\}|\$(\w+)`)
+	s := ref.ReplaceAllStringFunc(string(b), func(match string) string {
+		m := ref.FindStringSubmatch(match)
+		name := m[1]
+		if name == "" {
+			name = m[2]
+		}
+		if v, ok := t.vars[name]; ok {
+			return v
+		}
+		return match
+	})
+
+	return strings.NewReader(s), nil
+}
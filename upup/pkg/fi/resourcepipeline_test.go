@@ -0,0 +1,124 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fi
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"k8s.io/kops/util/pkg/hashing"
+)
+
+// oneShotResource simulates a Resource backed by a stream/subprocess that
+// cannot be opened twice: the second Open call returns an error, so a test
+// exercising it will fail loudly if a pipeline stage re-opens its base.
+type oneShotResource struct {
+	content string
+	opens   int
+}
+
+func (r *oneShotResource) Open() (io.Reader, error) {
+	r.opens++
+	if r.opens > 1 {
+		return nil, fmt.Errorf("resource already opened once and cannot be re-opened")
+	}
+	return strings.NewReader(r.content), nil
+}
+
+// upperTransformer uppercases its input; trivial stand-in for a real
+// Transformer so tests don't depend on gzip/tar framing.
+type upperTransformer struct{}
+
+func (upperTransformer) Name() string { return "upper" }
+
+func (upperTransformer) Transform(r io.Reader) (io.Reader, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return strings.NewReader(strings.ToUpper(string(b))), nil
+}
+
+func TestPipeOpensBaseExactlyOnce(t *testing.T) {
+	base := &oneShotResource{content: "hello"}
+	piped := Pipe(base, t.TempDir(), upperTransformer{}, upperTransformer{})
+
+	r, err := piped.Open()
+	if err != nil {
+		t.Fatalf("piped.Open() returned unexpected error: %v", err)
+	}
+
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("error reading piped output: %v", err)
+	}
+	if string(b) != "HELLO" {
+		t.Errorf("piped output = %q, want %q", string(b), "HELLO")
+	}
+	if base.opens != 1 {
+		t.Errorf("base resource was opened %d times, want 1", base.opens)
+	}
+}
+
+// TestPipeFingerprintSideEffectRunsOnCacheHit covers a warm cacheDir (e.g. a
+// fresh checkout reusing a pre-populated cache, as described in
+// pipelineResource.Open): the companion fingerprint file must still be written even
+// though the pipeline cache hit skips calling Transform.
+func TestPipeFingerprintSideEffectRunsOnCacheHit(t *testing.T) {
+	cacheDir := t.TempDir()
+	writePath := filepath.Join(t.TempDir(), "content.sha256")
+
+	newPipe := func() Resource {
+		return Pipe(&oneShotResource{content: "hello"}, cacheDir, Fingerprint(hashing.HashAlgorithmSHA256, writePath))
+	}
+
+	if _, err := newPipe().Open(); err != nil {
+		t.Fatalf("first Open() returned unexpected error: %v", err)
+	}
+	wantFingerprint, err := ioutil.ReadFile(writePath)
+	if err != nil {
+		t.Fatalf("expected fingerprint file to be written on first Open(): %v", err)
+	}
+
+	if err := os.Remove(writePath); err != nil {
+		t.Fatalf("error removing fingerprint file: %v", err)
+	}
+
+	if _, err := newPipe().Open(); err != nil {
+		t.Fatalf("second Open() (cache hit) returned unexpected error: %v", err)
+	}
+	gotFingerprint, err := ioutil.ReadFile(writePath)
+	if err != nil {
+		t.Fatalf("expected fingerprint file to be rewritten on cache hit: %v", err)
+	}
+	if string(gotFingerprint) != string(wantFingerprint) {
+		t.Errorf("fingerprint on cache hit = %q, want %q", gotFingerprint, wantFingerprint)
+	}
+}
+
+func TestPipeWithNoTransformersReturnsBase(t *testing.T) {
+	base := &oneShotResource{content: "hello"}
+	piped := Pipe(base, t.TempDir())
+	if piped != Resource(base) {
+		t.Errorf("Pipe() with no transformers should return the base resource unchanged")
+	}
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package httputil provides small helpers for making HTTP requests
+// resilient to transient failures, for use by clients (such as the kops
+// asset store) that talk to mirrors that may be flaky or rate-limited.
+package httputil
+
+import (
+	"math/rand"
+	"net/http"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// RetryConfig controls the exponential-backoff retry loop used by Do.
+type RetryConfig struct {
+	// InitialInterval is the delay before the first retry.
+	InitialInterval time.Duration
+	// MaxInterval caps the delay between retries.
+	MaxInterval time.Duration
+	// Multiplier is applied to the delay after each attempt.
+	Multiplier float64
+	// MaxAttempts is the total number of attempts (including the first),
+	// so MaxAttempts=1 means no retries.
+	MaxAttempts int
+	// Jitter is a fraction (0.0-1.0) of the computed delay to randomize,
+	// to avoid a thundering herd of clients retrying in lock-step.
+	Jitter float64
+}
+
+// DefaultRetryConfig returns sensible defaults for retrying requests
+// against public binary mirrors.
+func DefaultRetryConfig() RetryConfig {
+	return RetryConfig{
+		InitialInterval: 1 * time.Second,
+		MaxInterval:     30 * time.Second,
+		Multiplier:      2.0,
+		MaxAttempts:     5,
+		Jitter:          0.2,
+	}
+}
+
+// IsRetryable returns true if the response or error indicates a transient
+// failure that is worth retrying: network errors, 429, and 5xx responses.
+// 4xx responses (other than 429) are not retried, as a retry cannot change
+// the outcome of a malformed or unauthorized request.
+func IsRetryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	if resp == nil {
+		return false
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+	return resp.StatusCode >= 500 && resp.StatusCode < 600
+}
+
+// Do calls fn, retrying with exponential backoff (plus jitter) according
+// to cfg when the result is retryable per IsRetryable. fn is expected to
+// perform a single HTTP round-trip, such as http.Head or a GET.
+func Do(cfg RetryConfig, fn func() (*http.Response, error)) (*http.Response, error) {
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 1
+	}
+
+	delay := cfg.InitialInterval
+	if delay <= 0 {
+		delay = 1 * time.Second
+	}
+
+	var resp *http.Response
+	var err error
+	for attempt := 1; attempt <= cfg.MaxAttempts; attempt++ {
+		next, nextErr := fn()
+		if !IsRetryable(next, nextErr) {
+			return next, nextErr
+		}
+
+		// Close the body of every retryable response now rather than
+		// leaking the connection when resp/err are overwritten on the next
+		// iteration. This includes the last attempt: a retryable response
+		// returned after MaxAttempts carries only its status and headers.
+		if next != nil && next.Body != nil {
+			next.Body.Close()
+		}
+		resp, err = next, nextErr
+
+		if attempt == cfg.MaxAttempts {
+			break
+		}
+
+		sleep := withJitter(delay, cfg.Jitter)
+		klog.Infof("retryable error on attempt %d/%d, sleeping %v: %v", attempt, cfg.MaxAttempts, sleep, err)
+		time.Sleep(sleep)
+
+		delay = time.Duration(float64(delay) * cfg.Multiplier)
+		if cfg.MaxInterval > 0 && delay > cfg.MaxInterval {
+			delay = cfg.MaxInterval
+		}
+	}
+
+	return resp, err
+}
+
+func withJitter(d time.Duration, jitter float64) time.Duration {
+	if jitter <= 0 {
+		return d
+	}
+	delta := float64(d) * jitter
+	// Randomize uniformly within [d-delta, d+delta).
+	return d + time.Duration((rand.Float64()*2-1)*delta)
+}
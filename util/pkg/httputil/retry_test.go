@@ -0,0 +1,155 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package httputil
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// closeTrackingBody records whether Close was called, so tests can assert
+// that discarded (retried) responses don't leak their body.
+type closeTrackingBody struct {
+	io.Reader
+	closed *bool
+}
+
+func (b *closeTrackingBody) Close() error {
+	*b.closed = true
+	return nil
+}
+
+func TestIsRetryable(t *testing.T) {
+	grid := []struct {
+		name string
+		resp *http.Response
+		err  error
+		want bool
+	}{
+		{name: "network error", err: errors.New("connection reset"), want: true},
+		{name: "429", resp: &http.Response{StatusCode: http.StatusTooManyRequests}, want: true},
+		{name: "500", resp: &http.Response{StatusCode: http.StatusInternalServerError}, want: true},
+		{name: "599", resp: &http.Response{StatusCode: 599}, want: true},
+		{name: "200", resp: &http.Response{StatusCode: http.StatusOK}, want: false},
+		{name: "404", resp: &http.Response{StatusCode: http.StatusNotFound}, want: false},
+		{name: "400", resp: &http.Response{StatusCode: http.StatusBadRequest}, want: false},
+	}
+
+	for _, g := range grid {
+		t.Run(g.name, func(t *testing.T) {
+			if got := IsRetryable(g.resp, g.err); got != g.want {
+				t.Errorf("IsRetryable(%+v, %v) = %v, want %v", g.resp, g.err, got, g.want)
+			}
+		})
+	}
+}
+
+func TestDoRetriesUntilSuccess(t *testing.T) {
+	cfg := RetryConfig{
+		InitialInterval: time.Millisecond,
+		MaxInterval:     time.Millisecond,
+		Multiplier:      1,
+		MaxAttempts:     5,
+	}
+
+	attempts := 0
+	resp, err := Do(cfg, func() (*http.Response, error) {
+		attempts++
+		if attempts < 3 {
+			return &http.Response{StatusCode: http.StatusServiceUnavailable}, nil
+		}
+		return &http.Response{StatusCode: http.StatusOK}, nil
+	})
+	if err != nil {
+		t.Fatalf("Do returned unexpected error: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Do returned status %d, want 200", resp.StatusCode)
+	}
+	if attempts != 3 {
+		t.Errorf("Do made %d attempts, want 3", attempts)
+	}
+}
+
+func TestDoStopsAtMaxAttempts(t *testing.T) {
+	cfg := RetryConfig{
+		InitialInterval: time.Millisecond,
+		MaxInterval:     time.Millisecond,
+		Multiplier:      1,
+		MaxAttempts:     3,
+	}
+
+	attempts := 0
+	_, err := Do(cfg, func() (*http.Response, error) {
+		attempts++
+		return &http.Response{StatusCode: http.StatusInternalServerError}, nil
+	})
+	if err != nil {
+		t.Fatalf("Do returned unexpected error: %v", err)
+	}
+	if attempts != cfg.MaxAttempts {
+		t.Errorf("Do made %d attempts, want %d", attempts, cfg.MaxAttempts)
+	}
+}
+
+func TestDoClosesDiscardedResponseBodies(t *testing.T) {
+	cfg := RetryConfig{
+		InitialInterval: time.Millisecond,
+		MaxInterval:     time.Millisecond,
+		Multiplier:      1,
+		MaxAttempts:     3,
+	}
+
+	var closedFlags []*bool
+	_, err := Do(cfg, func() (*http.Response, error) {
+		closed := false
+		closedFlags = append(closedFlags, &closed)
+		return &http.Response{
+			StatusCode: http.StatusInternalServerError,
+			Body:       &closeTrackingBody{Reader: bytes.NewReader(nil), closed: &closed},
+		}, nil
+	})
+	if err != nil {
+		t.Fatalf("Do returned unexpected error: %v", err)
+	}
+
+	for i, closed := range closedFlags {
+		if !*closed {
+			t.Errorf("response body from attempt %d was never closed", i+1)
+		}
+	}
+}
+
+func TestDoDoesNotRetry4xx(t *testing.T) {
+	cfg := DefaultRetryConfig()
+
+	attempts := 0
+	_, err := Do(cfg, func() (*http.Response, error) {
+		attempts++
+		return &http.Response{StatusCode: http.StatusNotFound}, nil
+	})
+	if err != nil {
+		t.Fatalf("Do returned unexpected error: %v", err)
+	}
+	if attempts != 1 {
+		t.Errorf("Do made %d attempts for a 404, want 1", attempts)
+	}
+}